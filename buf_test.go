@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGrowLockedDoubles(t *testing.T) {
+	b := NewGrowingBuf(0)
+	b.nLock.Lock()
+	initial := len(b.bytes)
+	b.growLocked()
+	got := len(b.bytes)
+	b.nLock.Unlock()
+	if want := initial * 2; got != want {
+		t.Errorf("growLocked: got capacity %d, want %d", got, want)
+	}
+}
+
+func TestGrowLockedCapsAtGrowMax(t *testing.T) {
+	const max = 100 * 1024 // not a power of two multiple of the 64KB initial size
+	b := NewGrowingBuf(max)
+	b.nLock.Lock()
+	for len(b.bytes) < max {
+		b.growLocked()
+	}
+	got := len(b.bytes)
+	b.growLocked() // further growth past growMax must be a no-op
+	got2 := len(b.bytes)
+	b.nLock.Unlock()
+	if got != max {
+		t.Errorf("growLocked: grew to %d, want capped at %d", got, max)
+	}
+	if got2 != got {
+		t.Errorf("growLocked: grew past growMax on repeated call, %d -> %d", got, got2)
+	}
+}
+
+func TestCollectRingKeepsTrailingLines(t *testing.T) {
+	b := NewRingBuf(3)
+	b.Collect(strings.NewReader("l1\nl2\nl3\nl4\nl5\n"))
+
+	lines, _ := b.Stats()
+	if lines != 3 {
+		t.Errorf("Stats: got %d lines, want 3", lines)
+	}
+	b.nLock.Lock()
+	got := string(b.bytes[:b.n])
+	b.nLock.Unlock()
+	if want := "l3\nl4\nl5\n"; got != want {
+		t.Errorf("collectRing: got %q, want %q", got, want)
+	}
+}
+
+func TestCollectRingHandlesMissingTrailingNewline(t *testing.T) {
+	b := NewRingBuf(5)
+	b.Collect(strings.NewReader("a\nb\nc")) // EOF with no final newline
+	b.nLock.Lock()
+	got := string(b.bytes[:b.n])
+	b.nLock.Unlock()
+	if want := "a\nb\nc\n"; got != want {
+		t.Errorf("collectRing: got %q, want %q", got, want)
+	}
+}
+
+func TestCollectRingStripsCR(t *testing.T) {
+	b := NewRingBuf(5)
+	b.Collect(strings.NewReader("a\r\nb\r\n"))
+	b.nLock.Lock()
+	got := string(b.bytes[:b.n])
+	b.nLock.Unlock()
+	if want := "a\nb\n"; got != want {
+		t.Errorf("collectRing: got %q, want %q (CRLF input should yield LF-only lines)", got, want)
+	}
+}
+
+// A line longer than bufio.Scanner's old fixed 1MB token cap used to panic
+// collectRing outright; it must now just be kept like any other line.
+func TestCollectRingHandlesLineOverOldScannerLimit(t *testing.T) {
+	b := NewRingBuf(2)
+	longLine := strings.Repeat("x", 2*1024*1024)
+	b.Collect(strings.NewReader("first\n" + longLine + "\nlast\n"))
+
+	b.nLock.Lock()
+	got := string(b.bytes[:b.n])
+	b.nLock.Unlock()
+	if want := longLine + "\nlast\n"; got != want {
+		t.Errorf("collectRing mishandled an oversized line: got len %d, want len %d", len(got), len(want))
+	}
+}
+
+func TestGrowCapDoublesRingMax(t *testing.T) {
+	b := NewRingBuf(4)
+	b.GrowCap()
+	if b.ringMax != 8 {
+		t.Errorf("GrowCap: ringMax got %d, want 8", b.ringMax)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingEditNotReadyBeforeDelay(t *testing.T) {
+	var p pendingEdit
+	p.touch(0, "ls")
+	if _, _, ok := p.ready(); ok {
+		t.Fatal("ready() returned true before debounceDelay elapsed")
+	}
+}
+
+func TestPendingEditReadyAfterDelay(t *testing.T) {
+	var p pendingEdit
+	p.touch(2, "grep foo")
+	time.Sleep(debounceDelay + 20*time.Millisecond)
+
+	idx, command, ok := p.ready()
+	if !ok || idx != 2 || command != "grep foo" {
+		t.Fatalf("ready() = (%d, %q, %v), want (2, %q, true)", idx, command, ok, "grep foo")
+	}
+}
+
+func TestPendingEditResetsOnChange(t *testing.T) {
+	var p pendingEdit
+	p.touch(0, "gre")
+	time.Sleep(debounceDelay / 2)
+	p.touch(0, "grep") // a new command restarts the debounce clock
+
+	time.Sleep(debounceDelay/2 + 20*time.Millisecond)
+	if _, _, ok := p.ready(); ok {
+		t.Fatal("ready() returned true before debounceDelay elapsed since the last change")
+	}
+
+	time.Sleep(debounceDelay / 2)
+	idx, command, ok := p.ready()
+	if !ok || idx != 0 || command != "grep" {
+		t.Fatalf("ready() = (%d, %q, %v), want (0, %q, true)", idx, command, ok, "grep")
+	}
+}
+
+func TestPendingEditSameCommandDoesNotResetClock(t *testing.T) {
+	var p pendingEdit
+	p.touch(0, "ls")
+	time.Sleep(debounceDelay / 2)
+	p.touch(0, "ls") // re-touching the same (idx, command) must not restart the clock
+
+	time.Sleep(debounceDelay/2 + 20*time.Millisecond)
+	if _, _, ok := p.ready(); !ok {
+		t.Fatal("ready() returned false though debounceDelay elapsed since the first touch")
+	}
+}
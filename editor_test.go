@@ -0,0 +1,185 @@
+package main
+
+import "testing"
+
+// cursorMark marks the cursor position in a test command string; it's
+// distinct from '|' since '|' is meaningful pipeline syntax in these tests.
+const cursorMark = '‸' // CARET
+
+// newTestEditor builds an Editor directly (bypassing NewEditor, which reads
+// ~/.up_history) with command/cursor set from a string carrying cursorMark.
+func newTestEditor(commandWithCursor string) *Editor {
+	cursor := 0
+	command := commandWithCursor
+	for i, ch := range commandWithCursor {
+		if ch == cursorMark {
+			cursor = i
+			command = commandWithCursor[:i] + commandWithCursor[i+len(string(cursorMark)):]
+			break
+		}
+	}
+	return &Editor{command: []rune(command), cursor: cursor}
+}
+
+func TestWordLeft(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"foo bar‸", 4},
+		{"foo bar ‸", 4},
+		{"foo ‸bar", 0},
+		{"‸foo bar", 0},
+		{"foo  bar‸", 5},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.in)
+		if got := e.wordLeft(); got != tt.want {
+			t.Errorf("wordLeft(%q): got %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWordRight(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"‸foo bar", 3},
+		{"foo‸ bar", 7},
+		{"foo ‸bar", 7},
+		{"foo bar‸", 7},
+		{"foo  ‸bar", 8},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.in)
+		if got := e.wordRight(); got != tt.want {
+			t.Errorf("wordRight(%q): got %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKillRange(t *testing.T) {
+	e := newTestEditor("foo bar baz‸")
+	e.killRange(4, 8)
+	if got := e.String(); got != "foo baz" {
+		t.Errorf("after killRange: got %q, want %q", got, "foo baz")
+	}
+	if e.cursor != 4 {
+		t.Errorf("cursor after killRange: got %d, want 4", e.cursor)
+	}
+	if string(e.killBuf) != "bar " {
+		t.Errorf("killBuf after killRange: got %q, want %q", string(e.killBuf), "bar ")
+	}
+
+	// order-independent: from > to behaves the same as from < to
+	e2 := newTestEditor("foo bar baz‸")
+	e2.killRange(8, 4)
+	if got := e2.String(); got != "foo baz" {
+		t.Errorf("after reversed killRange: got %q, want %q", got, "foo baz")
+	}
+
+	// out-of-range bounds are clamped rather than panicking
+	e3 := newTestEditor("foo‸")
+	e3.killRange(-5, 100)
+	if got := e3.String(); got != "" {
+		t.Errorf("after out-of-range killRange: got %q, want empty", got)
+	}
+}
+
+func TestYank(t *testing.T) {
+	e := newTestEditor("foo bar baz‸")
+	e.killRange(4, 8)
+	e.cursor = len(e.command)
+	e.yank()
+	if got := e.String(); got != "foo bazbar " {
+		t.Errorf("after yank: got %q, want %q", got, "foo bazbar ")
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	tests := []struct {
+		in         string
+		want       string
+		wantCursor int
+	}{
+		{"ab‸", "ba", 2},
+		{"‸ab", "ba", 2},
+		{"abc‸d", "abdc", 4},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.in)
+		e.transpose()
+		if got := e.String(); got != tt.want {
+			t.Errorf("transpose(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+		if e.cursor != tt.wantCursor {
+			t.Errorf("transpose(%q) cursor: got %d, want %d", tt.in, e.cursor, tt.wantCursor)
+		}
+	}
+
+	// fewer than two runes: a no-op
+	e := newTestEditor("a‸")
+	e.transpose()
+	if got := e.String(); got != "a" {
+		t.Errorf("transpose single rune: got %q, want %q", got, "a")
+	}
+}
+
+func TestCurrentToken(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantStart  int
+		wantPrefix string
+	}{
+		{"gr‸", 0, "gr"},
+		{"grep foo | se‸", 11, "se"},
+		{"cat file.txt | grep -i fo‸o", 23, "fo"},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.in)
+		start, prefix := e.currentToken()
+		if start != tt.wantStart || prefix != tt.wantPrefix {
+			t.Errorf("currentToken(%q): got (%d, %q), want (%d, %q)", tt.in, start, prefix, tt.wantStart, tt.wantPrefix)
+		}
+	}
+}
+
+func TestCompleteNext(t *testing.T) {
+	e := newTestEditor("gr‸")
+	e.SetCompleter(func(prefix string) []string {
+		if prefix != "gr" {
+			t.Errorf("completer called with %q, want %q", prefix, "gr")
+		}
+		return []string{"grep", "grpc"}
+	})
+
+	e.completeNext()
+	if got := e.String(); got != "grep" {
+		t.Errorf("after first completeNext: got %q, want %q", got, "grep")
+	}
+	if e.cursor != len("grep") {
+		t.Errorf("cursor after first completeNext: got %d, want %d", e.cursor, len("grep"))
+	}
+
+	// Tab again cycles to the next candidate without re-querying the completer
+	e.completeNext()
+	if got := e.String(); got != "grpc" {
+		t.Errorf("after second completeNext: got %q, want %q", got, "grpc")
+	}
+
+	// and wraps back around
+	e.completeNext()
+	if got := e.String(); got != "grep" {
+		t.Errorf("after third completeNext: got %q, want %q", got, "grep")
+	}
+}
+
+func TestCompleteNextNoMatches(t *testing.T) {
+	e := newTestEditor("zz‸")
+	e.SetCompleter(func(prefix string) []string { return nil })
+	e.completeNext()
+	if got := e.String(); got != "zz" {
+		t.Errorf("completeNext with no matches should be a no-op: got %q, want %q", got, "zz")
+	}
+}
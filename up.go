@@ -3,12 +3,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/mattn/go-isatty"
@@ -16,6 +26,11 @@ import (
 )
 
 func main() {
+	maxBuf := flag.Int64("max-buf", 0, "let the input buffer grow as needed, up to this many bytes, instead of the fixed 40MB default")
+	tail := flag.Int("tail", 0, "only keep the last N lines of input (a ring buffer), for tailing logs and other unbounded streams")
+	flag.Parse()
+	configureBufMode(*maxBuf, *tail)
+
 	// TODO: Without below block, we'd hang with no piped input (see github.com/peco/peco, mattn/gof, fzf, etc.)
 	if isatty.IsTerminal(os.Stdin.Fd()) {
 		fmt.Fprintln(os.Stderr, "error: up requires some data piped on standard input, e.g.: `echo hello world | up`")
@@ -31,39 +46,72 @@ func main() {
 	defer termbox.Close()
 
 	var (
-		editor      = NewEditor("| ")
-		lastCommand = ""
-		subprocess  *Subprocess
-		inputBuf    = NewBuf()
-		buf         = inputBuf
+		stages   = []*Stage{{editor: NewEditor("| ")}}
+		focus    = 0
+		inputBuf = newConfiguredBuf()
 	)
 
-	// In background, start collecting input from stdin to internal buffer of size 40 MB, then pause it
+	// In background, start collecting input from stdin to the buffer, then pause it
 	go inputBuf.Collect(os.Stdin)
 
+	var (
+		pending     pendingEdit
+		pendingRing pendingRingRespawn
+		lastRingGen int
+		statusMsg   string
+	)
+
 	// Main loop
 main_loop:
 	for {
-		// Run command in background if needed
-		command := editor.String()
-		if command != lastCommand {
-			lastCommand = command
-			subprocess.Kill()
-			subprocess = StartSubprocess(inputBuf, command)
-			buf = subprocess.Buf
-		}
-
-		// Draw command input line
-		editor.Draw(0, 0, true)
-		buf.Draw(1)
+		// Re-spawn the first stage whose command changed, debounced so rapid
+		// edits don't thrash StartSubprocess (and the goroutines/processes it
+		// spawns) on every keystroke.
+		for i, st := range stages {
+			if command := st.editor.String(); command != st.lastCommand {
+				pending.touch(i, command)
+				break
+			}
+		}
+		if i, _, ok := pending.ready(); ok {
+			respawnFrom(stages, i, inputBuf)
+			pending = pendingEdit{}
+		}
+
+		// In --tail mode, stage 0's stdin is a one-shot snapshot of the ring
+		// (see Buf.NewReader) that goes stale as new lines evict old ones.
+		// Re-spawn it, debounced the same way as an edit, so the preview
+		// keeps tracking the tail instead of freezing at spawn time.
+		if inputBuf.mode == ModeRing && stages[0].lastCommand != "" {
+			if gen := inputBuf.Gen(); gen != lastRingGen {
+				pendingRing.touch(gen)
+			}
+			if gen, ok := pendingRing.ready(); ok {
+				lastRingGen = gen
+				respawnFrom(stages, 0, inputBuf)
+				pendingRing = pendingRingRespawn{}
+			}
+		}
+
+		drawStages(stages, focus, inputBuf, statusMsg)
 		termbox.Flush()
 
 		// Handle events
 		// TODO: how to interject with timer events triggering refresh?
 		switch ev := termbox.PollEvent(); ev.Type {
 		case termbox.EventKey:
-			// handle command-line editing keys
-			if editor.HandleKey(ev) {
+			st := stages[focus]
+			buf := stageBuf(stages, focus, inputBuf)
+
+			// handle command-line editing keys of the focused stage, unless
+			// it has ceded focus to its output pane (scroll mode / search)
+			if !st.scrollMode && !st.searching && st.editor.HandleKey(ev) {
+				continue main_loop
+			}
+			// handle output-pane paging/scrolling/search keys
+			_, termH := termbox.Size()
+			pageSize := termH / len(stages)
+			if handleScrollKey(st, buf, ev, pageSize) {
 				continue main_loop
 			}
 			// handle other keys
@@ -71,25 +119,55 @@ main_loop:
 			case termbox.KeyEsc, termbox.KeyCtrlC:
 				// quit
 				return
+			case termbox.KeyCtrlN:
+				// add a new stage after the last one, or just move focus forward
+				if focus == len(stages)-1 {
+					stages = append(stages, &Stage{editor: NewEditor("| ")})
+				}
+				focus++
+			case termbox.KeyCtrlP:
+				if focus > 0 {
+					focus--
+				}
+			case termbox.KeyCtrlG:
+				// toggle focus between the stage's editor and its output pane
+				st.scrollMode = !st.scrollMode
+			case termbox.KeyCtrlO:
+				showInPager(buf)
+			case termbox.KeyEnter:
+				// the user is deliberately accepting this stage's command,
+				// unlike the live-preview respawn that fires on every
+				// debounced edit; only record it in history here
+				st.editor.appendHistory(st.editor.String())
+			case termbox.KeyCtrlS:
+				for _, s := range stages {
+					s.editor.appendHistory(s.editor.String())
+				}
+				path, err := saveScript(stages)
+				if err != nil {
+					statusMsg = "save failed: " + err.Error()
+				} else {
+					statusMsg = "saved " + path
+				}
+			case termbox.KeyCtrlX:
+				// leave the TUI for good and exec the pipeline against the
+				// real terminal, fed by the buffered input plus whatever of
+				// os.Stdin is still streaming in
+				for _, s := range stages {
+					s.editor.appendHistory(s.editor.String())
+					s.subprocess.Kill()
+				}
+				execWithFullInput(stages, inputBuf)
 			}
 		}
 	}
 
 	// TODO: run command automatically in bg after first " " (or ^Enter), via `bash -c`
 	// TODO: auto-kill the child process on any edit
-	// TODO: allow scrolling the output preview with pgup/pgdn keys
-	// TODO: [LATER] Ctrl-O shows input via `less` or $PAGER
-	// TODO: ^X - save into executable file upN.sh (with #!/bin/bash) and quit
 	// TODO: properly show all licenses of dependencies on --version
-	// TODO: [LATER] allow increasing size of input buffer with some key
-	// TODO: [LATER] on ^X, leave TUI and run the command through buffered input, then unpause rest of input
-	// TODO: [LATER] allow adding more elements of pipeline (initially, just writing `foo | bar` should work)
 	// TODO: [LATER] allow invocation with partial command, like: `up grep -i`
 	// TODO: [LATER][MAYBE] allow reading upN.sh scripts
-	// TODO: [LATER] auto-save and/or save on Ctrl-S or something
-	// TODO: [MUCH LATER] readline-like rich editing support? and completion?
 	// TODO: [MUCH LATER] integration with fzf? and pindexis/marker?
-	// TODO: [LATER] forking and unforking pipelines
 	// TODO: [LATER] capture output of a running process (see: https://stackoverflow.com/q/19584825/98528)
 	// TODO: [LATER] richer TUI:
 	// - show # of read lines & kbytes
@@ -102,49 +180,695 @@ main_loop:
 	// TODO: [LATER] advertise on: HN, r/programming, r/golang, r/commandline, r/linux; data exploration? data science?
 }
 
+// debounceDelay is how long a stage's command must sit unchanged before it
+// is re-spawned, so a burst of keystrokes doesn't restart the pipeline once
+// per key.
+const debounceDelay = 150 * time.Millisecond
+
+// pendingEdit tracks the most recently edited stage's not-yet-applied
+// command, becoming ready() once it has sat unchanged for debounceDelay.
+type pendingEdit struct {
+	idx     int
+	command string
+	since   time.Time
+}
+
+// touch records (or re-records) an edit to stage idx's command, resetting
+// the debounce clock whenever the command itself changed.
+func (p *pendingEdit) touch(idx int, command string) {
+	if p.since.IsZero() || p.idx != idx || p.command != command {
+		p.idx = idx
+		p.command = command
+		p.since = time.Now()
+		// wake the main loop once the debounce window elapses, in case the
+		// user doesn't type again before then
+		time.AfterFunc(debounceDelay, func() { go termbox.Interrupt() })
+	}
+}
+
+func (p *pendingEdit) ready() (idx int, command string, ok bool) {
+	if p.since.IsZero() || time.Since(p.since) < debounceDelay {
+		return 0, "", false
+	}
+	return p.idx, p.command, true
+}
+
+// pendingRingRespawn mirrors pendingEdit's debounce, but for a ModeRing
+// input buffer's generation instead of a stage's command text: as new lines
+// evict old ones, stage 0's NewReader snapshot goes stale, so it needs
+// re-spawning the same debounceDelay after the buffer last changed.
+type pendingRingRespawn struct {
+	gen   int
+	since time.Time
+}
+
+// touch records (or re-records) the input buffer's latest generation,
+// resetting the debounce clock whenever it changed.
+func (p *pendingRingRespawn) touch(gen int) {
+	if p.since.IsZero() || p.gen != gen {
+		p.gen = gen
+		p.since = time.Now()
+		time.AfterFunc(debounceDelay, func() { go termbox.Interrupt() })
+	}
+}
+
+func (p *pendingRingRespawn) ready() (gen int, ok bool) {
+	if p.since.IsZero() || time.Since(p.since) < debounceDelay {
+		return 0, false
+	}
+	return p.gen, true
+}
+
+// Stage is one `| cmd` element of the pipeline being composed: its own
+// editor, and the Subprocess last spawned for its (possibly stale) command.
+type Stage struct {
+	editor      *Editor
+	subprocess  *Subprocess
+	lastCommand string
+
+	// scrollMode is toggled with Ctrl-G: while true, the editor doesn't see
+	// keys and PgUp/PgDn/arrows/Home/End/`/` scroll and search this stage's
+	// output pane instead.
+	scrollMode bool
+
+	// searching is a `/`-triggered regex search over the output pane,
+	// entered while in scrollMode.
+	searching   bool
+	searchQuery []rune
+}
+
+// respawnFrom kills and restarts stages[from:], chaining each stage's stdin
+// to the previous stage's (possibly still-growing) output buffer, while
+// leaving stages before `from` untouched. This is what lets editing stage K
+// re-run K..N without disturbing 0..K-1's buffers.
+func respawnFrom(stages []*Stage, from int, inputBuf *Buf) {
+	for i := from; i < len(stages); i++ {
+		st := stages[i]
+		st.subprocess.Kill()
+		var stdin io.Reader
+		if i == 0 {
+			stdin = inputBuf.NewReader()
+		} else {
+			stdin = stageBuf(stages, i-1, inputBuf).NewReader()
+		}
+		st.lastCommand = st.editor.String()
+		st.subprocess = StartSubprocess(stdin, st.lastCommand)
+	}
+}
+
+// stageBuf returns the buffer currently visible for stages[i]: its own
+// subprocess's output once it has one, otherwise whatever feeds its stdin
+// (the raw input, or the nearest upstream stage's buffer) as a passthrough
+// preview.
+func stageBuf(stages []*Stage, i int, inputBuf *Buf) *Buf {
+	if stages[i].subprocess != nil {
+		return stages[i].subprocess.Buf
+	}
+	if i == 0 {
+		return inputBuf
+	}
+	return stageBuf(stages, i-1, inputBuf)
+}
+
+// joinPipeline renders the stages as a single shell pipeline, substituting
+// `cat` for any stage left blank so the result is always runnable on its own.
+func joinPipeline(stages []*Stage) string {
+	parts := make([]string, len(stages))
+	for i, st := range stages {
+		cmd := strings.TrimSpace(st.editor.String())
+		if cmd == "" {
+			cmd = "cat"
+		}
+		parts[i] = cmd
+	}
+	return strings.Join(parts, " | ")
+}
+
+// saveScript writes the pipeline as an executable upN.sh, N being the lowest
+// integer for which that file doesn't already exist.
+func saveScript(stages []*Stage) (string, error) {
+	path, err := nextScriptPath()
+	if err != nil {
+		return "", err
+	}
+	// Each stage's text is already valid bash (it's run via `bash -c`
+	// directly, like the live preview), so embedding it verbatim in the
+	// script body is correct - no extra shell-quoting is needed here.
+	script := "#!/bin/bash\n" + joinPipeline(stages) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func nextScriptPath() (string, error) {
+	for n := 1; ; n++ {
+		path := fmt.Sprintf("up%d.sh", n)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// execWithFullInput leaves the TUI for good and re-runs the pipeline with
+// its stdin and stdout connected to the real terminal: stdin replays
+// whatever was already buffered, then falls through to the rest of the
+// still-streaming os.Stdin (see Buf.NewReader). For a ModeRing (--tail)
+// input buffer, NewReader is a one-shot snapshot, so stdin holds only the
+// lines retained at that moment and the rest of os.Stdin is not passed on.
+// It never returns.
+func execWithFullInput(stages []*Stage, inputBuf *Buf) {
+	command := joinPipeline(stages)
+	termbox.Close()
+
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Stdin = inputBuf.NewReader()
+	if inputBuf.Full() {
+		// Collect is parked waiting for GrowCap, which nothing calls once
+		// the TUI is gone, so read the rest of os.Stdin directly.
+		inputBuf.nLock.Lock()
+		buffered := append([]byte(nil), inputBuf.bytes[:inputBuf.n]...)
+		inputBuf.nLock.Unlock()
+		cmd.Stdin = io.MultiReader(bytes.NewReader(buffered), os.Stdin)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Run()
+
+	code := 1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	os.Exit(code)
+}
+
+// drawStages lays the stages out top-to-bottom, each getting an equal share
+// of the screen: its editor line, plus its output/preview pane below it. The
+// bottom row is reserved for the status line.
+func drawStages(stages []*Stage, focus int, inputBuf *Buf, statusMsg string) {
+	_, termH := termbox.Size()
+	h := termH - 1
+	if h < 2 {
+		h = termH
+	}
+	rowH := h / len(stages)
+	if rowH < 2 {
+		rowH = 2
+	}
+	for i, st := range stages {
+		y0 := i * rowH
+		y1 := y0 + rowH
+		if i == len(stages)-1 {
+			y1 = h
+		}
+		if st.searching {
+			drawSearchPrompt(st, y0)
+		} else {
+			st.editor.Draw(0, y0, i == focus && !st.scrollMode)
+		}
+		stageBuf(stages, i, inputBuf).Draw(y0+1, y1)
+	}
+	drawStatusLine(stages, focus, inputBuf, termH-1, statusMsg)
+}
+
+// drawSearchPrompt overlays a vim-style `/query` line in place of a
+// searching stage's editor.
+func drawSearchPrompt(st *Stage, y int) {
+	w, _ := termbox.Size()
+	for x := 0; x < w; x++ {
+		termbox.SetCell(x, y, ' ', termbox.ColorWhite, termbox.ColorBlue)
+	}
+	line := append([]rune{'/'}, st.searchQuery...)
+	for i, ch := range line {
+		if i >= w {
+			break
+		}
+		termbox.SetCell(i, y, ch, termbox.ColorWhite, termbox.ColorBlue)
+	}
+	if len(line) <= w {
+		termbox.SetCursor(len(line), y)
+	}
+}
+
+// drawStatusLine shows the focused stage's position, its output size,
+// whether input collection / the subprocess are still running, and the most
+// recent transient message (e.g. from Ctrl-S).
+func drawStatusLine(stages []*Stage, focus int, inputBuf *Buf, y int, statusMsg string) {
+	st := stages[focus]
+	lines, nbytes := stageBuf(stages, focus, inputBuf).Stats()
+
+	inputStatus := "collecting"
+	if inputBuf.Done() {
+		inputStatus = "done"
+	}
+	cmdStatus := "n/a"
+	if st.subprocess != nil {
+		if st.subprocess.Running() {
+			cmdStatus = "running"
+		} else {
+			cmdStatus = "exited"
+		}
+	}
+
+	text := fmt.Sprintf(" stage %d/%d  lines=%d bytes=%d  input:%s  cmd:%s",
+		focus+1, len(stages), lines, nbytes, inputStatus, cmdStatus)
+	if stageBuf(stages, focus, inputBuf).Full() {
+		text += "  [buffer full, press + in scroll mode to grow]"
+	}
+	if statusMsg != "" {
+		text += "  " + statusMsg
+	}
+	runes := []rune(text)
+	w, _ := termbox.Size()
+	for x := 0; x < w; x++ {
+		ch := ' '
+		if x < len(runes) {
+			ch = runes[x]
+		}
+		termbox.SetCell(x, y, ch, termbox.ColorBlack, termbox.ColorWhite)
+	}
+}
+
+// handleScrollKey handles paging/scrolling/search of a stage's output pane.
+// PgUp/PgDn/Home/End always apply; arrow up/down and `/`-search only apply
+// once the stage is in scrollMode (its editor has ceded focus via Ctrl-G).
+func handleScrollKey(st *Stage, buf *Buf, ev termbox.Event, pageSize int) bool {
+	if st.searching {
+		switch {
+		case ev.Key == termbox.KeyEnter:
+			st.searching = false
+			if re, err := regexp.Compile(string(st.searchQuery)); err == nil {
+				buf.SetSearch(re)
+			}
+		case ev.Key == termbox.KeyEsc:
+			st.searching = false
+		case ev.Key == termbox.KeyBackspace, ev.Key == termbox.KeyBackspace2:
+			if len(st.searchQuery) > 0 {
+				st.searchQuery = st.searchQuery[:len(st.searchQuery)-1]
+			}
+		case ev.Key == termbox.KeySpace:
+			st.searchQuery = append(st.searchQuery, ' ')
+		case ev.Ch != 0:
+			st.searchQuery = append(st.searchQuery, ev.Ch)
+		}
+		return true
+	}
+
+	switch ev.Key {
+	case termbox.KeyPgup:
+		buf.ScrollBy(-pageSize, pageSize)
+		return true
+	case termbox.KeyPgdn:
+		buf.ScrollBy(pageSize, pageSize)
+		return true
+	case termbox.KeyHome:
+		buf.ScrollTo(0)
+		return true
+	case termbox.KeyEnd:
+		buf.ScrollToEnd(pageSize)
+		return true
+	}
+
+	if !st.scrollMode {
+		return false
+	}
+	switch {
+	case ev.Key == termbox.KeyArrowUp:
+		buf.ScrollBy(-1, pageSize)
+	case ev.Key == termbox.KeyArrowDown:
+		buf.ScrollBy(1, pageSize)
+	case ev.Ch == '/':
+		st.searching = true
+		st.searchQuery = nil
+	case ev.Ch == '+':
+		// satisfies the old "allow increasing size of input buffer with some
+		// key" TODO: grows the buffer once it's reported full
+		buf.GrowCap()
+	default:
+		return false
+	}
+	return true
+}
+
+// showInPager suspends the TUI and hands the buffer's current contents to
+// $PAGER (default `less -R`), restoring the TUI once the pager exits.
+func showInPager(b *Buf) {
+	b.nLock.Lock()
+	data := append([]byte{}, b.bytes[:b.n]...)
+	b.nLock.Unlock()
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+
+	termbox.Close()
+	cmd := exec.Command("bash", "-c", pager)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Run()
+	if err := termbox.Init(); err != nil {
+		panic(err)
+	}
+}
+
+// BufMode selects how a Buf grows as Collect feeds it, chosen per-run via
+// the --max-buf and --tail flags (see configureBufMode).
+type BufMode int
+
+const (
+	// ModeFixed is a pre-allocated buffer of a fixed capacity (the original,
+	// and still default, behavior): Collect stops once it's full.
+	ModeFixed BufMode = iota
+	// ModeGrowing doubles its capacity as needed, up to growMax bytes.
+	ModeGrowing
+	// ModeRing keeps only the last ringMax lines, evicting older ones.
+	ModeRing
+)
+
 type Buf struct {
+	mode BufMode
+
 	bytes []byte
-	// NOTE: n can be written only by Collect
+	// NOTE: n, done and full can be written only by Collect and GrowCap
 	n     int
+	done  bool
+	full  bool
 	nLock sync.Mutex
+	// gen counts each time bytes[:n] is replaced with new content, bumped
+	// alongside n. ModeRing rewrites bytes wholesale on every line (evicting
+	// the oldest and appending the newest), so n alone can repeat across two
+	// different contents; gen is what actually changes every time.
+	gen int
+	// cond wakes readers/Collect blocked on n, done, or capacity changing.
+	cond *sync.Cond
+
+	growMax int // ModeGrowing: cap in bytes (<=0 means unbounded)
+	ringMax int // ModeRing: number of trailing lines to retain
+
+	// scrollY is the number of leading lines hidden above the viewport
+	// (PgUp/PgDn/Home/End/arrows, see handleScrollKey).
+	scrollY int
+	// searchRe, when set via SetSearch (`/` in scroll mode), is highlighted
+	// in inverse video as Draw renders matching text.
+	searchRe *regexp.Regexp
+
+	// hits caches searchRe.FindAllIndex(bytes[:n], -1), keyed by the
+	// (regexp, gen) pair it was computed for, so Draw only re-scans the
+	// buffer when the search or the collected data actually changed
+	// instead of on every frame.
+	hits    [][]int
+	hitsRe  *regexp.Regexp
+	hitsGen int
 }
 
+// NewBuf creates a Buf in the original, fixed-capacity mode.
 func NewBuf() *Buf {
 	const bufsize = 40 * 1024 * 1024 // 40 MB
-	return &Buf{bytes: make([]byte, bufsize)}
+	b := &Buf{mode: ModeFixed, bytes: make([]byte, bufsize)}
+	b.cond = sync.NewCond(&b.nLock)
+	return b
+}
+
+// NewGrowingBuf creates a Buf that doubles its capacity as needed, stopping
+// once it reaches maxSize bytes (<=0 means it may grow without limit).
+func NewGrowingBuf(maxSize int) *Buf {
+	const initial = 64 * 1024
+	b := &Buf{mode: ModeGrowing, growMax: maxSize, bytes: make([]byte, initial)}
+	b.cond = sync.NewCond(&b.nLock)
+	return b
+}
+
+// NewRingBuf creates a Buf that only ever keeps the last tailLines lines,
+// for tailing logs and other streams too large (or infinite) to fully buffer.
+func NewRingBuf(tailLines int) *Buf {
+	b := &Buf{mode: ModeRing, ringMax: tailLines}
+	b.cond = sync.NewCond(&b.nLock)
+	return b
 }
 
 func (b *Buf) Collect(r io.Reader) {
-	// TODO: allow stopping - take context?
+	if b.mode == ModeRing {
+		b.collectRing(r)
+		return
+	}
 	for {
-		n, err := r.Read(b.bytes[b.n:])
+		b.nLock.Lock()
+		for b.n == len(b.bytes) {
+			if b.mode == ModeGrowing && (b.growMax <= 0 || len(b.bytes) < b.growMax) {
+				b.growLocked()
+				continue
+			}
+			b.full = true
+			b.cond.Wait() // woken by GrowCap once it has made room
+		}
+		dst := b.bytes[b.n:]
+		b.nLock.Unlock()
+
+		n, err := r.Read(dst)
+
 		b.nLock.Lock()
 		b.n += n
+		if n > 0 {
+			b.gen++
+		}
+		b.full = false
+		b.cond.Broadcast()
 		b.nLock.Unlock()
 		go termbox.Interrupt()
 		if err == io.EOF {
-			// TODO: mark work as complete
+			b.markDone()
 			return
 		} else if err != nil {
 			// TODO: better handling of errors
 			panic(err)
 		}
-		if b.n == len(b.bytes) {
-			return
+	}
+}
+
+// collectRing feeds ModeRing: it keeps only the trailing ringMax lines,
+// rebuilding bytes/n from that window each time a line completes. It reads
+// with bufio.Reader rather than bufio.Scanner: ReadString grows its own
+// internal buffer to return a whole line regardless of length, so a single
+// line longer than any fixed token size (a minified log line, a long path)
+// is kept instead of panicking.
+func (b *Buf) collectRing(r io.Reader) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			lines = append(lines, strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+			b.nLock.Lock()
+			if len(lines) > b.ringMax {
+				lines = lines[len(lines)-b.ringMax:]
+			}
+			b.bytes = []byte(strings.Join(lines, "\n") + "\n")
+			b.n = len(b.bytes)
+			b.gen++
+			b.cond.Broadcast()
+			b.nLock.Unlock()
+			go termbox.Interrupt()
 		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// TODO: better handling of errors
+			panic(err)
+		}
+	}
+	b.markDone()
+}
+
+// growLocked doubles the buffer's capacity (capped at growMax for
+// ModeGrowing), copying over the valid prefix. Caller must hold nLock.
+func (b *Buf) growLocked() {
+	newSize := len(b.bytes) * 2
+	if newSize == 0 {
+		newSize = 64 * 1024
+	}
+	if b.mode == ModeGrowing && b.growMax > 0 && newSize > b.growMax {
+		newSize = b.growMax
+	}
+	if newSize <= len(b.bytes) {
+		return
+	}
+	grown := make([]byte, newSize)
+	copy(grown, b.bytes[:b.n])
+	b.bytes = grown
+}
+
+// GrowCap doubles the buffer's capacity at the user's request (the `+` key
+// in scroll mode), letting collection resume once it had stopped for being
+// full. For ModeFixed and ModeGrowing it's a no-op unless Collect is
+// actually blocked on capacity; a ModeGrowing buffer also has its growMax
+// doubled, so it can grow past the --max-buf cap it had reached. For
+// ModeRing it instead doubles ringMax, the number of lines kept.
+func (b *Buf) GrowCap() {
+	b.nLock.Lock()
+	defer b.nLock.Unlock()
+	if b.mode == ModeRing {
+		b.ringMax *= 2
+		return
+	}
+	if !b.full {
+		return
+	}
+	if b.mode == ModeGrowing && b.growMax > 0 {
+		b.growMax *= 2
 	}
+	b.growLocked()
+	b.full = false
+	b.cond.Broadcast()
+}
+
+func (b *Buf) markDone() {
+	b.nLock.Lock()
+	b.done = true
+	b.cond.Broadcast()
+	b.nLock.Unlock()
+}
+
+// Done reports whether Collect has reached the end of its input (EOF). A
+// buffer that has filled up is not done; see Full.
+func (b *Buf) Done() bool {
+	b.nLock.Lock()
+	defer b.nLock.Unlock()
+	return b.done
+}
+
+// Full reports whether Collect is currently blocked because the buffer has
+// no room left (only possible for ModeFixed, or ModeGrowing once growMax is
+// reached); see GrowCap.
+func (b *Buf) Full() bool {
+	b.nLock.Lock()
+	defer b.nLock.Unlock()
+	return b.full
+}
+
+// Gen returns the buffer's current generation, bumped each time bytes[:n] is
+// replaced with different content (see the gen field doc). For a ModeRing
+// buffer this changes on every evicted line, which the main loop polls to
+// know when a stale NewReader snapshot needs replacing.
+func (b *Buf) Gen() int {
+	b.nLock.Lock()
+	defer b.nLock.Unlock()
+	return b.gen
+}
+
+// Stats returns the number of newlines and the number of bytes currently
+// collected, for the status line.
+func (b *Buf) Stats() (lines, nbytes int) {
+	b.nLock.Lock()
+	data := b.bytes[:b.n]
+	b.nLock.Unlock()
+	return bytes.Count(data, []byte{'\n'}), len(data)
 }
 
-func (b *Buf) Draw(y0 int) {
+// SetSearch installs a regexp to highlight in the buffer's preview, resetting
+// the viewport so the search starts from the top.
+func (b *Buf) SetSearch(re *regexp.Regexp) {
 	b.nLock.Lock()
-	buf := b.bytes[:b.n]
+	b.searchRe = re
+	b.scrollY = 0
 	b.nLock.Unlock()
-	w, h := termbox.Size()
+}
+
+// maxScroll returns the highest scrollY that still fills a paneHeight-row
+// pane with content, so End/PgDn land on the last page instead of scrolling
+// past it into blank space. b.nLock must be held by the caller.
+func (b *Buf) maxScroll(paneHeight int) int {
+	lines := bytes.Count(b.bytes[:b.n], []byte{'\n'})
+	m := lines - paneHeight
+	if m < 0 {
+		m = 0
+	}
+	return m
+}
+
+// ScrollBy moves the viewport by delta lines (negative scrolls up), clamped
+// to the buffer's start and to maxScroll(paneHeight) so it can't run past
+// the last page.
+func (b *Buf) ScrollBy(delta, paneHeight int) {
+	b.nLock.Lock()
+	b.scrollY += delta
+	if b.scrollY < 0 {
+		b.scrollY = 0
+	}
+	if m := b.maxScroll(paneHeight); b.scrollY > m {
+		b.scrollY = m
+	}
+	b.nLock.Unlock()
+}
+
+// ScrollTo jumps the viewport to an absolute line offset.
+func (b *Buf) ScrollTo(y int) {
+	if y < 0 {
+		y = 0
+	}
+	b.nLock.Lock()
+	b.scrollY = y
+	b.nLock.Unlock()
+}
+
+// ScrollToEnd jumps the viewport to the last page that fills a
+// paneHeight-row pane, rather than past the end of the content.
+func (b *Buf) ScrollToEnd(paneHeight int) {
+	b.nLock.Lock()
+	b.scrollY = b.maxScroll(paneHeight)
+	b.nLock.Unlock()
+}
+
+// Draw renders the buffer's contents into the screen rows [y0, y1), letting
+// several stages' panes share the screen without overlapping. Lines before
+// scrollY are skipped, and any match of searchRe is shown in inverse video.
+func (b *Buf) Draw(y0, y1 int) {
+	b.nLock.Lock()
+	data := b.bytes[:b.n]
+	scrollY := b.scrollY
+	searchRe := b.searchRe
+	var hits [][]int
+	if searchRe != nil {
+		if b.hitsRe == searchRe && b.hitsGen == b.gen {
+			hits = b.hits
+		} else {
+			hits = searchRe.FindAllIndex(data, -1)
+			b.hits, b.hitsRe, b.hitsGen = hits, searchRe, b.gen
+		}
+	}
+	b.nLock.Unlock()
+
+	// hits is sorted and non-overlapping (as produced by FindAllIndex), so
+	// binary search for the first hit that could contain off instead of
+	// scanning every match per rendered character.
+	isHit := func(off int) bool {
+		i := sort.Search(len(hits), func(i int) bool { return hits[i][1] > off })
+		return i < len(hits) && hits[i][0] <= off
+	}
+
+	buf := data
+	for skip := scrollY; skip > 0 && len(buf) > 0; skip-- {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			buf = nil
+			break
+		}
+		buf = buf[i+1:]
+	}
+	off := len(data) - len(buf)
+
+	w, _ := termbox.Size()
 	// TODO: handle runes properly, including their visual width (mattn/go-runewidth)
 	x, y := 0, y0
-	for len(buf) > 0 && y < h {
+	for len(buf) > 0 && y < y1 {
 		ch, sz := utf8.DecodeRune(buf)
+		hit := isHit(off)
 		buf = buf[sz:]
+		off += sz
 		switch ch {
 		case '\n':
 			// TODO: clear to the end of screen line
@@ -152,16 +876,16 @@ func (b *Buf) Draw(y0 int) {
 			continue
 		case '\t':
 			const tabwidth = 8
-			b.putch(x, y, ' ')
+			b.putch(x, y, ' ', hit)
 			for x%tabwidth < (tabwidth - 1) {
 				x++
 				if x >= w {
 					break
 				}
-				b.putch(x, y, ' ')
+				b.putch(x, y, ' ', hit)
 			}
 		default:
-			b.putch(x, y, ch)
+			b.putch(x, y, ch, hit)
 		}
 		x++
 		if x > w {
@@ -170,21 +894,45 @@ func (b *Buf) Draw(y0 int) {
 	}
 }
 
-func (b *Buf) putch(x, y int, ch rune) {
-	termbox.SetCell(x, y, ch, termbox.ColorDefault, termbox.ColorDefault)
+func (b *Buf) putch(x, y int, ch rune, hit bool) {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+	if hit {
+		fg, bg = termbox.ColorBlack, termbox.ColorWhite
+	}
+	termbox.SetCell(x, y, ch, fg, bg)
 }
 
+// NewReader returns a reader over the buffer's current and future contents,
+// starting at its beginning. A Read blocks until at least one byte is
+// available or the buffer is done (EOF), rather than busy-looping.
+//
+// For a ModeRing buffer, which rewrites bytes wholesale as lines are
+// evicted, there's no stable "future contents" to follow: NewReader instead
+// returns a one-shot snapshot of the window as it stands right now. Callers
+// that feed this to a long-lived consumer (e.g. a pipeline stage's stdin)
+// only see input up to that snapshot; the main loop re-spawns stage 0 as
+// Gen() advances (see pendingRingRespawn) so a --tail pipeline's preview
+// keeps tracking new lines instead of going stale.
 func (b *Buf) NewReader() io.Reader {
-	// TODO: return EOF if input is fully buffered?
+	if b.mode == ModeRing {
+		b.nLock.Lock()
+		snapshot := append([]byte(nil), b.bytes[:b.n]...)
+		b.nLock.Unlock()
+		return bytes.NewReader(snapshot)
+	}
 	i := 0
 	return funcReader(func(p []byte) (n int, err error) {
 		b.nLock.Lock()
-		end := b.n
+		for b.n <= i && !b.done {
+			b.cond.Wait()
+		}
+		end, done := b.n, b.done
 		b.nLock.Unlock()
-		// TODO: don't return (0,nil), instead wait until at least 1 available,
-		// or return EOF on completion?
 		n = copy(p, b.bytes[i:end])
 		i += n
+		if n == 0 && done {
+			return 0, io.EOF
+		}
 		return n, nil
 	})
 }
@@ -193,18 +941,91 @@ type funcReader func([]byte) (int, error)
 
 func (f funcReader) Read(p []byte) (int, error) { return f(p) }
 
+// bufMode and its companions hold the --max-buf/--tail flags as parsed by
+// configureBufMode, so every Buf created afterwards (the input buffer and
+// each stage's subprocess buffer) picks up the same mode.
+var (
+	bufMode    = ModeFixed
+	bufMaxSize int
+	bufTail    int
+)
+
+// configureBufMode records the input-buffer mode selected via --max-buf and
+// --tail; newConfiguredBuf uses it to build every Buf for this run.
+func configureBufMode(maxBuf int64, tail int) {
+	switch {
+	case tail > 0:
+		bufMode = ModeRing
+		bufTail = tail
+	case maxBuf > 0:
+		bufMode = ModeGrowing
+		bufMaxSize = int(maxBuf)
+	default:
+		bufMode = ModeFixed
+	}
+}
+
+// newConfiguredBuf creates a Buf in the mode selected by configureBufMode.
+func newConfiguredBuf() *Buf {
+	switch bufMode {
+	case ModeRing:
+		return NewRingBuf(bufTail)
+	case ModeGrowing:
+		return NewGrowingBuf(bufMaxSize)
+	default:
+		return NewBuf()
+	}
+}
+
+// Completer returns completion candidates for the given prefix (the partial
+// token the user is currently typing).
+type Completer func(prefix string) []string
+
 type Editor struct {
 	prompt []rune
 	// TODO: make editor multiline. Reuse gocui or something for this?
 	// TODO: rename 'command' to 'data' or 'value' or something more generic
 	command []rune
 	cursor  int
-	// lastw is length of command on last Draw
+	// lastw is length of command (or overlay) on last Draw
 	lastw int
+
+	// killBuf holds the last killed (Ctrl-K/U/W, Alt-D) text, yankable via Ctrl-Y.
+	killBuf []rune
+
+	// history is loaded from, and appended to, historyFile (~/.up_history).
+	history     []string
+	historyFile string
+
+	// reverse-incremental-search (Ctrl-R) state
+	searching    bool
+	searchQuery  []rune
+	searchIdx    int
+	preSearchCmd []rune
+	preSearchCur int
+
+	// tab-completion state
+	completer       Completer
+	completions     []string
+	completionIdx   int
+	completionStart int
+	completionEnd   int
 }
 
 func NewEditor(prompt string) *Editor {
-	return &Editor{prompt: []rune(prompt)}
+	historyFile := defaultHistoryFile()
+	return &Editor{
+		prompt:      []rune(prompt),
+		historyFile: historyFile,
+		history:     loadHistory(historyFile),
+		completer:   defaultCompleter,
+	}
+}
+
+// SetCompleter installs a pluggable Tab-completer, replacing the default
+// `compgen -c`-based one.
+func (e *Editor) SetCompleter(c Completer) {
+	e.completer = c
 }
 
 func (e *Editor) String() string {
@@ -212,6 +1033,10 @@ func (e *Editor) String() string {
 }
 
 func (e *Editor) Draw(x, y int, setcursor bool) {
+	if e.searching {
+		e.drawSearch(x, y, setcursor)
+		return
+	}
 	for i, ch := range e.prompt {
 		termbox.SetCell(x+i, y, ch, termbox.ColorWhite, termbox.ColorBlue)
 	}
@@ -228,10 +1053,55 @@ func (e *Editor) Draw(x, y int, setcursor bool) {
 	e.lastw = len(e.command)
 }
 
+// drawSearch renders the `(reverse-i-search)` overlay in place of the normal
+// prompt while a Ctrl-R search is in progress.
+func (e *Editor) drawSearch(x, y int, setcursor bool) {
+	label := fmt.Sprintf("(reverse-i-search)`%s': ", string(e.searchQuery))
+	match := ""
+	if e.searchIdx >= 0 && e.searchIdx < len(e.history) {
+		match = e.history[e.searchIdx]
+	}
+	line := []rune(label + match)
+	for i, ch := range line {
+		termbox.SetCell(x+i, y, ch, termbox.ColorWhite, termbox.ColorBlue)
+	}
+	for i := len(line); i < e.lastw; i++ {
+		termbox.SetCell(x+i, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+	}
+	e.lastw = len(line)
+	if setcursor {
+		termbox.SetCursor(x+len([]rune(label)), y)
+	}
+}
+
 func (e *Editor) HandleKey(ev termbox.Event) bool {
 	if ev.Type != termbox.EventKey {
 		return false
 	}
+	if e.searching {
+		return e.handleSearchKey(ev)
+	}
+	if ev.Key == termbox.KeyTab {
+		e.completeNext()
+		return true
+	}
+	// any key other than Tab interrupts a completion cycle
+	e.completions = nil
+
+	if ev.Mod&termbox.ModAlt != 0 && ev.Ch != 0 {
+		switch ev.Ch {
+		case 'b':
+			e.cursor = e.wordLeft()
+			return true
+		case 'f':
+			e.cursor = e.wordRight()
+			return true
+		case 'd':
+			e.killRange(e.cursor, e.wordRight())
+			return true
+		}
+	}
+
 	if ev.Ch != 0 {
 		e.insert(ev.Ch)
 		return true
@@ -245,13 +1115,43 @@ func (e *Editor) HandleKey(ev termbox.Event) bool {
 	case termbox.KeyDelete:
 		e.delete(0)
 	case termbox.KeyArrowLeft:
-		if e.cursor > 0 {
+		// NOTE: termbox-go doesn't distinguish Ctrl-Left from Alt-Left, so we
+		// treat either modifier as "word back" (Ctrl-B/F below cover plain moves).
+		if ev.Mod&termbox.ModAlt != 0 {
+			e.cursor = e.wordLeft()
+		} else if e.cursor > 0 {
 			e.cursor--
 		}
 	case termbox.KeyArrowRight:
+		if ev.Mod&termbox.ModAlt != 0 {
+			e.cursor = e.wordRight()
+		} else if e.cursor < len(e.command) {
+			e.cursor++
+		}
+	case termbox.KeyCtrlA:
+		e.cursor = 0
+	case termbox.KeyCtrlE:
+		e.cursor = len(e.command)
+	case termbox.KeyCtrlB:
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case termbox.KeyCtrlF:
 		if e.cursor < len(e.command) {
 			e.cursor++
 		}
+	case termbox.KeyCtrlW:
+		e.killRange(e.wordLeft(), e.cursor)
+	case termbox.KeyCtrlK:
+		e.killRange(e.cursor, len(e.command))
+	case termbox.KeyCtrlU:
+		e.killRange(0, e.cursor)
+	case termbox.KeyCtrlY:
+		e.yank()
+	case termbox.KeyCtrlT:
+		e.transpose()
+	case termbox.KeyCtrlR:
+		e.startSearch()
 	default:
 		return false
 	}
@@ -275,36 +1175,361 @@ func (e *Editor) delete(dx int) {
 	e.cursor = pos
 }
 
+// killRange removes command[from:to] (order-independent), stashing it in the
+// kill ring for a later Ctrl-Y, and leaves the cursor at the cut point.
+func (e *Editor) killRange(from, to int) {
+	if from > to {
+		from, to = to, from
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > len(e.command) {
+		to = len(e.command)
+	}
+	if from >= to {
+		e.cursor = from
+		return
+	}
+	e.killBuf = append([]rune{}, e.command[from:to]...)
+	e.command = append(e.command[:from], e.command[to:]...)
+	e.cursor = from
+}
+
+func (e *Editor) yank() {
+	for _, ch := range e.killBuf {
+		e.insert(ch)
+	}
+}
+
+// transpose swaps the two runes surrounding the cursor (Ctrl-T), like
+// readline's "transpose-chars"; at the end of the line it swaps the last two
+// runes, and at the start of the line it swaps the first two.
+func (e *Editor) transpose() {
+	if len(e.command) < 2 {
+		return
+	}
+	i := e.cursor
+	if i >= len(e.command) {
+		i = len(e.command) - 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	e.command[i-1], e.command[i] = e.command[i], e.command[i-1]
+	if e.cursor < len(e.command) {
+		e.cursor = i + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r)
+}
+
+// wordLeft returns the cursor position after moving back to the start of the
+// previous word (Alt-B / Ctrl-Left).
+func (e *Editor) wordLeft() int {
+	i := e.cursor
+	for i > 0 && !isWordRune(e.command[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(e.command[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordRight returns the cursor position after moving forward past the end of
+// the next word (Alt-F / Ctrl-Right).
+func (e *Editor) wordRight() int {
+	i, n := e.cursor, len(e.command)
+	for i < n && !isWordRune(e.command[i]) {
+		i++
+	}
+	for i < n && isWordRune(e.command[i]) {
+		i++
+	}
+	return i
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".up_history")
+}
+
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var hist []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			hist = append(hist, line)
+		}
+	}
+	return hist
+}
+
+// appendHistory records cmd as the most recently run pipeline, both in memory
+// (for Ctrl-R) and in historyFile (for future invocations of up).
+func (e *Editor) appendHistory(cmd string) {
+	if cmd == "" || e.historyFile == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == cmd {
+		return
+	}
+	e.history = append(e.history, cmd)
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, cmd)
+}
+
+func (e *Editor) startSearch() {
+	e.searching = true
+	e.searchQuery = nil
+	e.preSearchCmd = append([]rune{}, e.command...)
+	e.preSearchCur = e.cursor
+	e.searchIdx = e.findMatch(len(e.history) - 1)
+}
+
+func (e *Editor) handleSearchKey(ev termbox.Event) bool {
+	switch {
+	case ev.Key == termbox.KeyCtrlR:
+		// repeated Ctrl-R: step to the next older match
+		e.searchIdx = e.findMatch(e.searchIdx - 1)
+		return true
+	case ev.Key == termbox.KeyEsc, ev.Key == termbox.KeyCtrlC:
+		e.command = e.preSearchCmd
+		e.cursor = e.preSearchCur
+		e.searching = false
+		return true
+	case ev.Key == termbox.KeyEnter:
+		e.acceptSearch()
+		return true
+	case ev.Key == termbox.KeyBackspace, ev.Key == termbox.KeyBackspace2:
+		if len(e.searchQuery) > 0 {
+			e.searchQuery = e.searchQuery[:len(e.searchQuery)-1]
+			e.searchIdx = e.findMatch(len(e.history) - 1)
+		}
+		return true
+	case ev.Key == termbox.KeySpace:
+		e.searchQuery = append(e.searchQuery, ' ')
+		e.searchIdx = e.findMatch(len(e.history) - 1)
+		return true
+	case ev.Ch != 0:
+		e.searchQuery = append(e.searchQuery, ev.Ch)
+		e.searchIdx = e.findMatch(len(e.history) - 1)
+		return true
+	default:
+		// any other key accepts the current match, then is handled normally
+		e.acceptSearch()
+		return false
+	}
+}
+
+// findMatch scans history backwards from index `from`, returning the index
+// of the first entry containing the current search query (or -1). With an
+// empty query every entry matches, and a `from` outside history wraps to the
+// newest entry instead of returning -1.
+func (e *Editor) findMatch(from int) int {
+	if len(e.searchQuery) == 0 {
+		if from >= 0 && from < len(e.history) {
+			return from
+		}
+		return len(e.history) - 1
+	}
+	q := string(e.searchQuery)
+	for i := from; i >= 0; i-- {
+		if strings.Contains(e.history[i], q) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *Editor) acceptSearch() {
+	if e.searchIdx >= 0 && e.searchIdx < len(e.history) {
+		e.command = []rune(e.history[e.searchIdx])
+		e.cursor = len(e.command)
+	}
+	e.searching = false
+}
+
+// completeNext cycles through completions for the token under the cursor,
+// fetching a fresh candidate list from the completer on the first Tab press.
+func (e *Editor) completeNext() {
+	if e.completer == nil {
+		return
+	}
+	if len(e.completions) == 0 {
+		start, prefix := e.currentToken()
+		matches := e.completer(prefix)
+		if len(matches) == 0 {
+			return
+		}
+		e.completions = matches
+		e.completionIdx = 0
+		e.completionStart = start
+		e.completionEnd = e.cursor
+	} else {
+		e.completionIdx = (e.completionIdx + 1) % len(e.completions)
+	}
+	cand := []rune(e.completions[e.completionIdx])
+	e.command = append(e.command[:e.completionStart], append(cand, e.command[e.completionEnd:]...)...)
+	e.completionEnd = e.completionStart + len(cand)
+	e.cursor = e.completionEnd
+}
+
+// currentToken returns the start offset and text of the last `|`-stage's
+// last whitespace-delimited word up to the cursor, i.e. what Tab completes.
+func (e *Editor) currentToken() (start int, prefix string) {
+	upto := e.command[:e.cursor]
+	stageStart := 0
+	for i, ch := range upto {
+		if ch == '|' {
+			stageStart = i + 1
+		}
+	}
+	tokStart := stageStart
+	for i := stageStart; i < len(upto); i++ {
+		if unicode.IsSpace(upto[i]) {
+			tokStart = i + 1
+		}
+	}
+	return tokStart, string(upto[tokStart:])
+}
+
+// defaultCompleter completes executable names on $PATH by shelling out to
+// `compgen -c`, the same mechanism an interactive bash uses.
+func defaultCompleter(prefix string) []string {
+	out, err := exec.Command("bash", "-c", `compgen -c "$1"`, "_", prefix).Output()
+	if err != nil {
+		return nil
+	}
+	var matches []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			matches = append(matches, line)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
 type Subprocess struct {
 	Buf    *Buf
 	cancel context.CancelFunc
+	exited int32 // set via atomic once the command has returned
+
+	stdin  *cancelReader
+	stdout *cancelReader
 }
 
-func StartSubprocess(inputBuf *Buf, command string) *Subprocess {
+func StartSubprocess(stdin io.Reader, command string) *Subprocess {
 	ctx, cancel := context.WithCancel(context.TODO())
 	s := &Subprocess{
-		Buf:    NewBuf(),
+		Buf:    newConfiguredBuf(),
 		cancel: cancel,
+		stdin:  newCancelReader(stdin),
 	}
 	r, w := io.Pipe()
-	go s.Buf.Collect(r)
+	s.stdout = newCancelReader(r)
+	go s.Buf.Collect(s.stdout)
 
 	cmd := exec.CommandContext(ctx, "bash", "-c", command)
 	cmd.Stdout = w
 	cmd.Stderr = w
-	cmd.Stdin = inputBuf.NewReader()
+	cmd.Stdin = s.stdin
 	err := cmd.Start()
 	if err != nil {
 		fmt.Fprintf(w, "up: %s", err)
+		w.Close()
+		atomic.StoreInt32(&s.exited, 1)
 		return s
 	}
-	go cmd.Wait()
+	go func() {
+		cmd.Wait()
+		// Close the write end so s.Buf.Collect observes EOF. Without this, a
+		// downstream stage chained onto s.Buf.NewReader() never sees its
+		// stdin close either, so commands that only emit at EOF (wc, sort,
+		// uniq -c, ...) hang forever with no output.
+		w.Close()
+		// Also unblock a goroutine still parked reading s.stdin, the same
+		// way Kill does: a process that exits on its own without consuming
+		// all of its stdin (e.g. `head -1`) would otherwise leave that read
+		// permanently stuck, leaking it just like an un-respawned Kill would.
+		s.stdin.Cancel()
+		atomic.StoreInt32(&s.exited, 1)
+		go termbox.Interrupt()
+	}()
 	return s
 }
 
+// Running reports whether the subprocess is still executing. A nil
+// Subprocess (no command spawned yet) is never running.
+func (s *Subprocess) Running() bool {
+	if s == nil {
+		return false
+	}
+	return atomic.LoadInt32(&s.exited) == 0
+}
+
+// Kill cancels the subprocess's context and unblocks any goroutine currently
+// parked in a Read on its stdin or stdout pipe, so re-running a pipeline
+// stage doesn't leak readers across restarts.
 func (s *Subprocess) Kill() {
 	if s == nil {
 		return
 	}
 	s.cancel()
+	s.stdin.Cancel()
+	s.stdout.Cancel()
+}
+
+// cancelReader wraps a reader whose blocked Read can be preempted from
+// another goroutine via Cancel, which makes a pending Read return io.EOF
+// immediately. The underlying Read may still be in flight afterwards (e.g. a
+// still-blocked read of os.Stdin); that goroutine is abandoned rather than
+// made to block the caller.
+type cancelReader struct {
+	r      io.Reader
+	cancel chan struct{}
+	once   sync.Once
+}
+
+func newCancelReader(r io.Reader) *cancelReader {
+	return &cancelReader{r: r, cancel: make(chan struct{})}
+}
+
+func (c *cancelReader) Cancel() {
+	c.once.Do(func() { close(c.cancel) })
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := c.r.Read(p)
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-c.cancel:
+		return 0, io.EOF
+	}
 }